@@ -22,11 +22,11 @@ import (
 	"io/ioutil"
 	"math"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"reflect"
 	"sync"
 
-	"github.com/fsnotify/fsnotify"
 	"github.com/go-chassis/go-archaius/core"
 	"github.com/go-chassis/go-archaius/sources/utils"
 	"github.com/go-mesh/openlogging"
@@ -34,6 +34,12 @@ import (
 	"time"
 )
 
+//coalesceWindow is how long the watch pool waits for further changes on the
+//same file before re-reading and publishing it, so that several rapid
+//writes to the same file (editors, atomic renames, bulk copies) collapse
+//into a single reload
+const coalesceWindow = 100 * time.Millisecond
+
 const (
 	//ConfigMapConfigSourceConst is a variable of type string
 	ConfigMapConfigSourceConst = "ConfigMapSource"
@@ -62,44 +68,95 @@ type ConfigInfo struct {
 
 type configMapSource struct {
 	Configurations map[string]*ConfigInfo
-	files          []file
-	fileHandlers   map[string]utils.FileHandler
+	filesMu        sync.RWMutex
+	files          map[string]fileMeta
+	registrations  map[string]fileMeta
 	watchPool      *watch
 	fileLock       sync.Mutex
 	priority       int
+	newWatcher     func() (Watcher, error)
+	mounts         map[string]*configMapMount
+	globs          []*globReg
+	reloadLock     sync.Mutex
+	signalLock     sync.Mutex
+	signalChan     chan os.Signal
 	sync.RWMutex
 }
 
-type file struct {
-	filePath string
+//fileMeta is the priority and handler a file or a registered directory was
+//added with, keyed by absolute path in configMapSource.files/registrations
+type fileMeta struct {
 	priority uint32
+	handler  utils.FileHandler
 }
 
 type watch struct {
-	watcher         *fsnotify.Watcher
+	watcher         Watcher
 	callback        core.DynamicConfigCallback
 	configMapSource *configMapSource
+
+	timerLock sync.Mutex
+	timers    map[string]*time.Timer
+	stopped   bool
+
 	sync.RWMutex
 }
 
+//ConfigMapSourceOption customizes a configMapSource created by NewConfigMapSource
+type ConfigMapSourceOption func(*configMapSource)
+
+//WithPollingWatcher makes the source detect file changes by periodically
+//walking the registered paths and comparing mtimes instead of relying on
+//fsnotify/inotify. Useful on filesystems where inotify is unreliable (NFS,
+//overlayfs, Kubernetes projected volumes on some kernels) or once the
+//fsnotify watch limit has been exhausted. interval <= 0 uses a default of 5s
+func WithPollingWatcher(interval time.Duration) ConfigMapSourceOption {
+	return func(cmSource *configMapSource) {
+		cmSource.newWatcher = func() (Watcher, error) {
+			return newPollWatcher(interval), nil
+		}
+	}
+}
+
+//WithFsNotifyWatcher selects the default, inotify-backed watcher. It only
+//needs to be passed explicitly when overriding an earlier option
+func WithFsNotifyWatcher() ConfigMapSourceOption {
+	return func(cmSource *configMapSource) {
+		cmSource.newWatcher = newFsNotifyWatcher
+	}
+}
+
 var _ core.ConfigSource = &configMapSource{}
 var _ ConfigMapSource = &configMapSource{}
 
-var configMapConfigSource *configMapSource
+var (
+	configMapConfigSource *configMapSource
+	configMapSourceOnce   sync.Once
+)
 
 //ConfigMapSource is interface
 type ConfigMapSource interface {
 	core.ConfigSource
 	AddFile(filePath string, priority uint32, handler utils.FileHandler) error
+	AddFiles(pattern string, priority uint32, handler utils.FileHandler, opts ...AddFilesOption) error
+	AddConfigMapMount(mountDir string, handler utils.FileHandler) error
+	EnableSignalReload(sig os.Signal) error
 }
 
-//NewConfigMapSource creates a source which can handler recurse directory
-func NewConfigMapSource() ConfigMapSource {
-	if configMapConfigSource == nil {
+//NewConfigMapSource creates a source which can handler recurse directory.
+//By default it watches the filesystem with fsnotify; pass WithPollingWatcher
+//to fall back to periodic polling
+func NewConfigMapSource(opts ...ConfigMapSourceOption) ConfigMapSource {
+	configMapSourceOnce.Do(func() {
 		configMapConfigSource = new(configMapSource)
 		configMapConfigSource.priority = configMapSourcePriority
-		configMapConfigSource.files = make([]file, 0)
-		configMapConfigSource.fileHandlers = make(map[string]utils.FileHandler)
+		configMapConfigSource.files = make(map[string]fileMeta)
+		configMapConfigSource.registrations = make(map[string]fileMeta)
+		configMapConfigSource.newWatcher = newFsNotifyWatcher
+	})
+
+	for _, opt := range opts {
+		opt(configMapConfigSource)
 	}
 
 	return configMapConfigSource
@@ -112,12 +169,12 @@ func (cmSource *configMapSource) AddFile(p string, priority uint32, handle utils
 		return err
 	}
 
-	if cmSource.isFileSrcExist(path) {
+	if cmSource.fileExists(path) {
 		return nil
 	}
-	cmSource.fileHandlers[path] = handle
+	cmSource.registerRoot(path, priority, handle)
 
-	err = filepath.Walk(p,
+	return filepath.Walk(p,
 		func(p string, info os.FileInfo, err error) error {
 			if err != nil {
 				return err
@@ -141,8 +198,18 @@ func (cmSource *configMapSource) AddFile(p string, priority uint32, handle utils
 				}
 			case RegularFile:
 				err := cmSource.handleFile(fs, priority, handle)
+				if dir, ok := symlinkedMountDir(path); ok {
+					// recorded regardless of whether a watch pool exists yet,
+					// so a mount registered before DynamicConfigHandler runs
+					// is not silently dropped; startWatchPool replays
+					// cmSource.mountDirs() the same way it replays filePaths()
+					cmSource.registerMountKey(dir, path, priority, handle)
+				}
 				if cmSource.watchPool != nil {
 					cmSource.watchPool.AddWatchFile(path)
+					if dir, ok := symlinkedMountDir(path); ok {
+						cmSource.watchPool.AddWatchFile(dir)
+					}
 				}
 				if err != nil {
 					openlogging.GetLogger().Errorf("Failed to handle file [%s] [%s]", path, err)
@@ -155,8 +222,6 @@ func (cmSource *configMapSource) AddFile(p string, priority uint32, handle utils
 
 			return nil
 		})
-
-	return nil
 }
 
 func (cmSource *configMapSource) getFilePath(filePath string) (string, error) {
@@ -173,17 +238,6 @@ func (cmSource *configMapSource) getFilePath(filePath string) (string, error) {
 	return path, nil
 }
 
-func (cmSource *configMapSource) isFileSrcExist(filePath string) bool {
-	var exist bool
-	for _, file := range cmSource.files {
-		if filePath == file.filePath {
-			return true
-		}
-	}
-
-	return exist
-}
-
 func getFileType(fs *os.File) ConfigMapFileSourceTypes {
 	fileInfo, err := fs.Stat()
 	if err != nil {
@@ -202,6 +256,12 @@ func getFileType(fs *os.File) ConfigMapFileSourceTypes {
 }
 
 func (cmSource *configMapSource) handleFile(file *os.File, priority uint32, handle utils.FileHandler) error {
+	// serializes every reload path (fsnotify, the poll watcher, a signal
+	// reload, a configmap mount swap) so two of them can never interleave
+	// their read of Configurations with their replacement of it
+	cmSource.reloadLock.Lock()
+	defer cmSource.reloadLock.Unlock()
+
 	Content, err := ioutil.ReadFile(file.Name())
 	if err != nil {
 		return err
@@ -210,16 +270,13 @@ func (cmSource *configMapSource) handleFile(file *os.File, priority uint32, hand
 	if handle != nil {
 		config, err = handle(file.Name(), Content)
 	} else {
-		config, err = utils.Convert2JavaProps(file.Name(), Content)
+		config, err = handlerFor(file.Name())(file.Name(), Content)
 	}
 	if err != nil {
 		return fmt.Errorf("failed to pull configurations from [%s] file, %s", file.Name(), err)
 	}
 
-	err = cmSource.handlePriority(file.Name(), priority)
-	if err != nil {
-		return fmt.Errorf("failed to handle priority of [%s], %s", file.Name(), err)
-	}
+	cmSource.setFileMeta(file.Name(), priority, handle)
 
 	events := cmSource.compareUpdate(config, file.Name())
 	if cmSource.watchPool != nil && cmSource.watchPool.callback != nil { // if file source already added and try to add
@@ -231,35 +288,6 @@ func (cmSource *configMapSource) handleFile(file *os.File, priority uint32, hand
 	return nil
 }
 
-func (cmSource *configMapSource) handlePriority(filePath string, priority uint32) error {
-	cmSource.Lock()
-	newFilePriority := make([]file, 0)
-	var prioritySet bool
-	for _, f := range cmSource.files {
-
-		if f.filePath == filePath && f.priority == priority {
-			prioritySet = true
-			newFilePriority = append(newFilePriority, file{
-				filePath: filePath,
-				priority: priority,
-			})
-		}
-		newFilePriority = append(newFilePriority, f)
-	}
-
-	if !prioritySet {
-		newFilePriority = append(newFilePriority, file{
-			filePath: filePath,
-			priority: priority,
-		})
-	}
-
-	cmSource.files = newFilePriority
-	cmSource.Unlock()
-
-	return nil
-}
-
 func (cmSource *configMapSource) GetConfigurations() (map[string]interface{}, error) {
 	configMap := make(map[string]interface{})
 
@@ -283,7 +311,6 @@ func (cmSource *configMapSource) GetConfigurationByKey(key string) (interface{},
 
 	for ckey, confInfo := range cmSource.Configurations {
 		if confInfo == nil {
-			confInfo.Value = nil
 			continue
 		}
 
@@ -326,7 +353,12 @@ func (cmSource *configMapSource) DynamicConfigHandler(callback core.DynamicConfi
 }
 
 func newWatchPool(callback core.DynamicConfigCallback, cfgSrc *configMapSource) (*watch, error) {
-	watcher, err := fsnotify.NewWatcher()
+	newWatcher := cfgSrc.newWatcher
+	if newWatcher == nil {
+		newWatcher = newFsNotifyWatcher
+	}
+
+	watcher, err := newWatcher()
 	if err != nil {
 		openlogging.GetLogger().Error("New file watcher failed:" + err.Error())
 		return nil, err
@@ -336,25 +368,53 @@ func newWatchPool(callback core.DynamicConfigCallback, cfgSrc *configMapSource)
 	watch.callback = callback
 	watch.configMapSource = cfgSrc
 	watch.watcher = watcher
+	watch.timers = make(map[string]*time.Timer)
 	openlogging.GetLogger().Info("create new watcher")
 	return watch, nil
 }
 
 func (wth *watch) startWatchPool() {
 	go wth.watchFile()
-	for _, file := range wth.configMapSource.files {
-		f, err := filepath.Abs(file.filePath)
+	for _, filePath := range wth.configMapSource.filePaths() {
+		f, err := filepath.Abs(filePath)
 		if err != nil {
-			openlogging.GetLogger().Errorf("failed to get Directory info from: %s file: %s", file.filePath, err)
+			openlogging.GetLogger().Errorf("failed to get Directory info from: %s file: %s", filePath, err)
 			return
 		}
 
 		err = wth.watcher.Add(f)
 		if err != nil {
-			openlogging.GetLogger().Errorf("add watcher file: %+v fail %s", file, err)
+			openlogging.GetLogger().Errorf("add watcher file: %s fail %s", filePath, err)
 			return
 		}
 	}
+
+	for _, g := range wth.configMapSource.globsSnapshot() {
+		wth.watchGlobRoot(g)
+	}
+
+	for _, dir := range wth.configMapSource.mountDirs() {
+		wth.AddWatchFile(dir)
+	}
+}
+
+//watchGlobRoot adds every directory under g.root to the watcher, not just
+//g.root itself, since fsnotify never watches recursively on its own: without
+//this, a file created in a nested subdirectory of a "**" pattern would never
+//produce an event
+func (wth *watch) watchGlobRoot(g *globReg) {
+	err := filepath.Walk(g.root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() {
+			wth.AddWatchFile(p)
+		}
+		return nil
+	})
+	if err != nil {
+		openlogging.GetLogger().Errorf("failed to walk glob root [%s]: %s", g.root, err)
+	}
 }
 
 func (wth *watch) AddWatchFile(filePath string) {
@@ -368,7 +428,7 @@ func (wth *watch) AddWatchFile(filePath string) {
 func (wth *watch) watchFile() {
 	for {
 		select {
-		case event, ok := <-wth.watcher.Events:
+		case event, ok := <-wth.watcher.Events():
 			if !ok {
 				openlogging.GetLogger().Warnf("file watcher stop")
 				return
@@ -380,12 +440,32 @@ func (wth *watch) watchFile() {
 			}
 			//openlogging.GetLogger().Debugf("file event %s, operation is %d. reload it.", event.Name, event.Op)
 
-			if event.Op == fsnotify.Remove {
+			if isK8sDataSymlinkEvent(event.Name) && (event.Op == Create || event.Op == Remove) {
+				// Kubernetes projected ConfigMap/Secret volumes swap the
+				// "..data" symlink atomically, which surfaces as a
+				// Remove+Create on the mount directory and never a Write on
+				// the leaf files, so it is handled before the generic
+				// Remove/Write paths below
+				wth.configMapSource.reloadConfigMapMount(filepath.Dir(event.Name))
+				continue
+			}
+
+			if event.Op == Remove {
 				//openlogging.GetLogger().Warnf("the file change mode: %s, continue", event.String())
 				continue
 			}
 
-			if event.Op == fsnotify.Rename {
+			if event.Op == Create {
+				if info, statErr := os.Stat(event.Name); statErr == nil && info.IsDir() {
+					// a new subdirectory under a "**" glob root needs its own
+					// watch added, and its own pre-existing files matched and
+					// registered, for the pattern to stay recursive
+					wth.configMapSource.addGlobDirectory(event.Name)
+					continue
+				}
+			}
+
+			if event.Op == Rename {
 				wth.watcher.Remove(event.Name)
 				// check existence of file
 				_, err := os.Open(event.Name)
@@ -398,24 +478,69 @@ func (wth *watch) watchFile() {
 				continue
 			}
 
-			if event.Op == fsnotify.Create {
-				time.Sleep(time.Millisecond)
-			}
-
-			wth.configMapSource.updateFile(wth, event)
+			wth.coalesce(event)
 
-		case err := <-wth.watcher.Errors:
+		case err := <-wth.watcher.Errors():
 			openlogging.GetLogger().Debugf("watch file error:", err)
 			return
 		}
 	}
 }
 
-func (cmSource *configMapSource) updateFile(wth *watch, event fsnotify.Event) {
-	if wth.configMapSource.isFileSrcExist(event.Name) {
-		handle := wth.configMapSource.fileHandlers[event.Name]
+//coalesce delays handling of event by coalesceWindow, restarting the delay
+//on every further event for the same file, so that a burst of writes to one
+//file (editors, atomic renames, bulk copies) results in a single reload
+func (wth *watch) coalesce(event Event) {
+	wth.timerLock.Lock()
+	defer wth.timerLock.Unlock()
+
+	if wth.stopped {
+		return
+	}
+
+	if t, ok := wth.timers[event.Name]; ok {
+		t.Stop()
+	}
+
+	wth.timers[event.Name] = time.AfterFunc(coalesceWindow, func() {
+		wth.timerLock.Lock()
+		delete(wth.timers, event.Name)
+		stopped := wth.stopped
+		wth.timerLock.Unlock()
+
+		// Cleanup may have run and torn down configMapSource between this
+		// timer being scheduled and firing; stopped guards against calling
+		// updateFile on a configMapSource that is no longer there
+		if stopped {
+			return
+		}
+
+		wth.configMapSource.updateFile(wth, event)
+	})
+}
+
+//stopTimers cancels every pending coalesce timer and marks wth as stopped so
+//no timer already in flight calls back into configMapSource once Cleanup has
+//torn it down
+func (wth *watch) stopTimers() {
+	wth.timerLock.Lock()
+	defer wth.timerLock.Unlock()
+
+	wth.stopped = true
+	for name, t := range wth.timers {
+		t.Stop()
+		delete(wth.timers, name)
+	}
+}
+
+func (cmSource *configMapSource) updateFile(wth *watch, event Event) {
+	if meta, ok := wth.configMapSource.fileMetaFor(event.Name); ok {
+		wth.configMapSource.reloadLock.Lock()
+		defer wth.configMapSource.reloadLock.Unlock()
+
+		handle := meta.handler
 		if handle == nil {
-			handle = utils.Convert2JavaProps
+			handle = handlerFor(event.Name)
 		}
 		content, err := ioutil.ReadFile(event.Name)
 		if err != nil {
@@ -434,18 +559,21 @@ func (cmSource *configMapSource) updateFile(wth *watch, event fsnotify.Event) {
 			wth.callback.OnEvent(e)
 		}
 	} else {
-		var priority uint32 = configMapSourcePriority
-		for _, file := range wth.configMapSource.files {
-			if strings.Contains(event.Name, file.filePath) {
-				priority = file.priority
+		for _, g := range wth.configMapSource.globsSnapshot() {
+			if g.matches(event.Name) {
+				wth.configMapSource.AddFile(event.Name, g.priority, g.handler)
+				return
 			}
 		}
 
+		priority := uint32(configMapSourcePriority)
 		var fileHandler utils.FileHandler
-		for path, handler := range wth.configMapSource.fileHandlers {
-			if strings.Contains(event.Name, path) {
-				fileHandler = handler
-			}
+		// pick the most specific (longest path) registered ancestor
+		// directory instead of the last one a map/slice iteration happens
+		// to visit, so the outcome no longer depends on iteration order
+		if meta, ok := wth.configMapSource.registrationFor(event.Name); ok {
+			priority = meta.priority
+			fileHandler = meta.handler
 		}
 		wth.configMapSource.AddFile(event.Name, priority, fileHandler)
 	}
@@ -462,20 +590,14 @@ func (cmSource *configMapSource) compareUpdate(newconf map[string]interface{}, f
 	cmSource.Lock()
 	defer cmSource.Unlock()
 
-	var filePathPriority uint32 = math.MaxUint32
-	for _, file := range cmSource.files {
-		if file.filePath == filePath {
-			filePathPriority = file.priority
-		}
-	}
-
-	if filePathPriority == math.MaxUint32 {
+	filePathMeta, ok := cmSource.fileMetaFor(filePath)
+	if !ok {
 		return nil
 	}
+	filePathPriority := filePathMeta.priority
 
 	for key, confInfo := range cmSource.Configurations {
 		if confInfo == nil {
-			confInfo.Value = nil
 			continue
 		}
 
@@ -500,11 +622,9 @@ func (cmSource *configMapSource) compareUpdate(newconf map[string]interface{}, f
 		default:
 			newConfValue, ok := newconf[key]
 			if ok {
-				var priority uint32 = math.MaxUint32
-				for _, file := range cmSource.files {
-					if file.filePath == confInfo.FilePath {
-						priority = file.priority
-					}
+				priority := uint32(math.MaxUint32)
+				if meta, ok := cmSource.fileMetaFor(confInfo.FilePath); ok {
+					priority = meta.priority
 				}
 
 				if priority == filePathPriority {
@@ -571,12 +691,34 @@ func (cmSource *configMapSource) Cleanup() error {
 	}
 
 	if cmSource.watchPool != nil {
+		// stop every pending coalesce timer first: one could otherwise fire
+		// after configMapSource is nilled out below and panic on its first
+		// field access
+		cmSource.watchPool.stopTimers()
 		cmSource.watchPool.configMapSource = nil
 		cmSource.watchPool.callback = nil
 		cmSource.watchPool = nil
 	}
 	cmSource.Configurations = nil
-	cmSource.files = make([]file, 0)
+
+	cmSource.filesMu.Lock()
+	cmSource.files = make(map[string]fileMeta)
+	cmSource.registrations = make(map[string]fileMeta)
+	cmSource.globs = nil
+	cmSource.filesMu.Unlock()
+
+	cmSource.Lock()
+	cmSource.mounts = nil
+	cmSource.Unlock()
+
+	cmSource.signalLock.Lock()
+	if cmSource.signalChan != nil {
+		signal.Stop(cmSource.signalChan)
+		close(cmSource.signalChan)
+		cmSource.signalChan = nil
+	}
+	cmSource.signalLock.Unlock()
+
 	return nil
 }
 
@@ -0,0 +1,184 @@
+/*
+ * Copyright 2019 Huawei Technologies Co., Ltd
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package configmapource
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestNewConfigMapSourceIsSingletonUnderConcurrency(t *testing.T) {
+	var wg sync.WaitGroup
+	sources := make([]ConfigMapSource, 50)
+
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sources[i] = NewConfigMapSource()
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 1; i < len(sources); i++ {
+		if sources[i] != sources[0] {
+			t.Fatalf("NewConfigMapSource returned different instances under concurrent access")
+		}
+	}
+}
+
+func TestAddFileConcurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "configmapsource-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := NewConfigMapSource().(*configMapSource)
+	defer src.Cleanup()
+
+	var wg sync.WaitGroup
+	paths := make([]string, 0, 20)
+	for i := 0; i < 20; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("file%d.properties", i))
+		if err := ioutil.WriteFile(path, []byte(fmt.Sprintf("key%d=value%d\n", i, i)), 0600); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+
+		wg.Add(1)
+		go func(p string) {
+			defer wg.Done()
+			if err := src.AddFile(p, DefaultConfigMapPriority, nil); err != nil {
+				t.Errorf("AddFile(%s) failed: %s", p, err)
+			}
+		}(path)
+	}
+	wg.Wait()
+
+	for _, p := range paths {
+		if !src.fileExists(p) {
+			t.Fatalf("expected %s to be registered", p)
+		}
+	}
+}
+
+func TestAddFilesConcurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "configmapsource-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := NewConfigMapSource().(*configMapSource)
+	defer src.Cleanup()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sub := filepath.Join(dir, fmt.Sprintf("sub%d", i))
+			if err := os.MkdirAll(sub, 0700); err != nil {
+				t.Error(err)
+				return
+			}
+			path := filepath.Join(sub, fmt.Sprintf("file%d.properties", i))
+			if err := ioutil.WriteFile(path, []byte(fmt.Sprintf("key%d=value%d\n", i, i)), 0600); err != nil {
+				t.Error(err)
+				return
+			}
+			if err := src.AddFiles(filepath.Join(sub, "*.properties"), DefaultConfigMapPriority, nil); err != nil {
+				t.Errorf("AddFiles(%s) failed: %s", sub, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := len(src.globsSnapshot()); got != 20 {
+		t.Fatalf("expected 20 registered glob patterns, got %d", got)
+	}
+}
+
+func TestCleanupConcurrentWithAddFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "configmapsource-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := NewConfigMapSource().(*configMapSource)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("file%d.properties", i))
+		if err := ioutil.WriteFile(path, []byte(fmt.Sprintf("key%d=value%d\n", i, i)), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		wg.Add(1)
+		go func(p string) {
+			defer wg.Done()
+			_ = src.AddFile(p, DefaultConfigMapPriority, nil)
+		}(path)
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_ = src.Cleanup()
+	}()
+
+	wg.Wait()
+}
+
+func TestGetConfigurationByKeyIgnoresNilEntries(t *testing.T) {
+	src := &configMapSource{
+		Configurations: map[string]*ConfigInfo{
+			"nil-entry": nil,
+			"present":   {FilePath: "/tmp/whatever", Value: "value"},
+		},
+	}
+
+	if _, err := src.GetConfigurationByKey("nil-entry"); err == nil {
+		t.Fatalf("expected nil entry to be treated as missing, not panic")
+	}
+
+	v, err := src.GetConfigurationByKey("present")
+	if err != nil || v != "value" {
+		t.Fatalf("expected present key to resolve, got %v, %s", v, err)
+	}
+}
+
+func TestRegistrationForPicksMostSpecificAncestor(t *testing.T) {
+	src := &configMapSource{
+		registrations: map[string]fileMeta{
+			"/etc/config":        {priority: 1},
+			"/etc/config/nested": {priority: 2},
+		},
+	}
+
+	meta, ok := src.registrationFor("/etc/config/nested/new.yaml")
+	if !ok || meta.priority != 2 {
+		t.Fatalf("expected the most specific ancestor registration to win, got %+v, %v", meta, ok)
+	}
+}
@@ -0,0 +1,136 @@
+/*
+ * Copyright 2019 Huawei Technologies Co., Ltd
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package configmapource
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/go-chassis/go-archaius/sources/utils"
+)
+
+//fileExists reports whether filePath has already been handled at least once
+func (cmSource *configMapSource) fileExists(filePath string) bool {
+	cmSource.filesMu.RLock()
+	defer cmSource.filesMu.RUnlock()
+
+	_, ok := cmSource.files[filePath]
+	return ok
+}
+
+//setFileMeta records the priority and handler a file was last handled with
+func (cmSource *configMapSource) setFileMeta(filePath string, priority uint32, handler utils.FileHandler) {
+	cmSource.filesMu.Lock()
+	defer cmSource.filesMu.Unlock()
+
+	cmSource.files[filePath] = fileMeta{priority: priority, handler: handler}
+}
+
+//fileMetaFor looks up the priority and handler a file was last handled with
+func (cmSource *configMapSource) fileMetaFor(filePath string) (fileMeta, bool) {
+	cmSource.filesMu.RLock()
+	defer cmSource.filesMu.RUnlock()
+
+	meta, ok := cmSource.files[filePath]
+	return meta, ok
+}
+
+//filePaths snapshots every file path currently handled
+func (cmSource *configMapSource) filePaths() []string {
+	cmSource.filesMu.RLock()
+	defer cmSource.filesMu.RUnlock()
+
+	paths := make([]string, 0, len(cmSource.files))
+	for p := range cmSource.files {
+		paths = append(paths, p)
+	}
+	return paths
+}
+
+//registerRoot records the priority and handler an AddFile call was made
+//with, keyed by the absolute form of the path it was given. AddFiles and the
+//watcher register files through AddFile too, so their paths end up here as
+//well. It backs registrationFor's fallback lookup for files discovered later
+//under a registered directory
+func (cmSource *configMapSource) registerRoot(rootPath string, priority uint32, handler utils.FileHandler) {
+	cmSource.filesMu.Lock()
+	defer cmSource.filesMu.Unlock()
+
+	cmSource.registrations[rootPath] = fileMeta{priority: priority, handler: handler}
+}
+
+//registeredRoots snapshots every path an AddFile call was made with, i.e. the
+//keys of registrations; files found only by AddFile walking a registered
+//directory are not among them
+func (cmSource *configMapSource) registeredRoots() []string {
+	cmSource.filesMu.RLock()
+	defer cmSource.filesMu.RUnlock()
+
+	roots := make([]string, 0, len(cmSource.registrations))
+	for root := range cmSource.registrations {
+		roots = append(roots, root)
+	}
+	return roots
+}
+
+//registrationFor returns the priority and handler of the most specific (the
+//longest path) registered root that is an ancestor of, or equal to, path.
+//Used when a newly created file is not itself registered yet, replacing the
+//previous strings.Contains scan that picked whichever root a map iteration
+//happened to visit last
+func (cmSource *configMapSource) registrationFor(path string) (fileMeta, bool) {
+	cmSource.filesMu.RLock()
+	defer cmSource.filesMu.RUnlock()
+
+	var (
+		best    fileMeta
+		found   bool
+		bestLen = -1
+	)
+
+	for root, meta := range cmSource.registrations {
+		if root != path && !strings.HasPrefix(path, root+string(filepath.Separator)) {
+			continue
+		}
+		if len(root) > bestLen {
+			bestLen, best, found = len(root), meta, true
+		}
+	}
+
+	return best, found
+}
+
+//addGlob records a pattern registered through AddFiles, guarded by the same
+//filesMu as files/registrations so a watcher goroutine reading the slice
+//while AddFiles appends to it can never observe a torn slice header
+func (cmSource *configMapSource) addGlob(reg *globReg) {
+	cmSource.filesMu.Lock()
+	defer cmSource.filesMu.Unlock()
+
+	cmSource.globs = append(cmSource.globs, reg)
+}
+
+//globsSnapshot returns a copy of every pattern registered through AddFiles,
+//safe to range over without holding filesMu
+func (cmSource *configMapSource) globsSnapshot() []*globReg {
+	cmSource.filesMu.RLock()
+	defer cmSource.filesMu.RUnlock()
+
+	globs := make([]*globReg, len(cmSource.globs))
+	copy(globs, cmSource.globs)
+	return globs
+}
@@ -0,0 +1,162 @@
+/*
+ * Copyright 2019 Huawei Technologies Co., Ltd
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package configmapource
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	"github.com/go-chassis/go-archaius/sources/utils"
+	"gopkg.in/ini.v1"
+	"gopkg.in/yaml.v2"
+)
+
+//FileHandlerRegistry maps a file extension to the utils.FileHandler that
+//understands it, so AddFile/updateFile no longer have to guess a format by
+//falling back to utils.Convert2JavaProps for everything
+type FileHandlerRegistry struct {
+	mu       sync.RWMutex
+	handlers map[string]utils.FileHandler
+}
+
+var defaultRegistry = newDefaultFileHandlerRegistry()
+
+func newDefaultFileHandlerRegistry() *FileHandlerRegistry {
+	r := &FileHandlerRegistry{handlers: make(map[string]utils.FileHandler)}
+	r.handlers[".yaml"] = yamlFileHandler
+	r.handlers[".yml"] = yamlFileHandler
+	r.handlers[".json"] = jsonFileHandler
+	r.handlers[".toml"] = tomlFileHandler
+	r.handlers[".ini"] = iniFileHandler
+	r.handlers[".properties"] = utils.Convert2JavaProps
+	return r
+}
+
+//RegisterHandler plugs a utils.FileHandler in for every file matching ext
+//(e.g. ".hcl", ".env", ".cue"), so downstream users can support formats this
+//package does not ship a parser for without forking it
+func RegisterHandler(ext string, h utils.FileHandler) {
+	defaultRegistry.mu.Lock()
+	defer defaultRegistry.mu.Unlock()
+	defaultRegistry.handlers[ext] = h
+}
+
+//handlerFor resolves the FileHandler to use for name when the caller did not
+//supply one: an extension match in the registry first, then a best-effort
+//content sniff when the extension is unknown or unregistered
+func handlerFor(name string) utils.FileHandler {
+	ext := strings.ToLower(filepath.Ext(name))
+
+	defaultRegistry.mu.RLock()
+	h, ok := defaultRegistry.handlers[ext]
+	defaultRegistry.mu.RUnlock()
+	if ok {
+		return h
+	}
+
+	return sniffFileHandler
+}
+
+//sniffFileHandler is used when name's extension is not registered: it tries
+//JSON, then YAML, then falls back to Java properties, in that order, since
+//JSON and YAML both reject most malformed input outright while properties
+//parsing rarely errors
+func sniffFileHandler(name string, content []byte) (map[string]interface{}, error) {
+	if config, err := jsonFileHandler(name, content); err == nil {
+		return config, nil
+	}
+	if config, err := yamlFileHandler(name, content); err == nil {
+		return config, nil
+	}
+	return utils.Convert2JavaProps(name, content)
+}
+
+func jsonFileHandler(name string, content []byte) (map[string]interface{}, error) {
+	config := make(map[string]interface{})
+	if err := json.Unmarshal(content, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse [%s] as json: %s", name, err)
+	}
+	return config, nil
+}
+
+func yamlFileHandler(name string, content []byte) (map[string]interface{}, error) {
+	raw := make(map[string]interface{})
+	if err := yaml.Unmarshal(content, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse [%s] as yaml: %s", name, err)
+	}
+	config := make(map[string]interface{})
+	flattenConfig("", raw, config)
+	return config, nil
+}
+
+func tomlFileHandler(name string, content []byte) (map[string]interface{}, error) {
+	raw := make(map[string]interface{})
+	if _, err := toml.Decode(string(content), &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse [%s] as toml: %s", name, err)
+	}
+	config := make(map[string]interface{})
+	flattenConfig("", raw, config)
+	return config, nil
+}
+
+func iniFileHandler(name string, content []byte) (map[string]interface{}, error) {
+	cfg, err := ini.Load(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse [%s] as ini: %s", name, err)
+	}
+
+	config := make(map[string]interface{})
+	for _, section := range cfg.Sections() {
+		for _, key := range section.Keys() {
+			k := key.Name()
+			if section.Name() != ini.DefaultSection {
+				k = section.Name() + "." + k
+			}
+			config[k] = key.Value()
+		}
+	}
+	return config, nil
+}
+
+//flattenConfig turns the nested maps YAML/TOML decode into into the flat,
+//dot-separated key space the rest of configMapSource operates on, matching
+//the shape utils.Convert2JavaProps already produces for properties files
+func flattenConfig(prefix string, in map[string]interface{}, out map[string]interface{}) {
+	for k, v := range in {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+
+		switch child := v.(type) {
+		case map[string]interface{}:
+			flattenConfig(key, child, out)
+		case map[interface{}]interface{}:
+			converted := make(map[string]interface{}, len(child))
+			for ck, cv := range child {
+				converted[fmt.Sprintf("%v", ck)] = cv
+			}
+			flattenConfig(key, converted, out)
+		default:
+			out[key] = v
+		}
+	}
+}
@@ -0,0 +1,96 @@
+/*
+ * Copyright 2019 Huawei Technologies Co., Ltd
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package configmapource
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHandlerForExtension(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		key     string
+		want    interface{}
+	}{
+		{"/etc/config/app.yaml", "key: value\n", "key", "value"},
+		{"/etc/config/app.YML", "key: value\n", "key", "value"},
+		{"/etc/config/app.json", `{"key":"value"}`, "key", "value"},
+		{"/etc/config/app.toml", "key = \"value\"\n", "key", "value"},
+		{"/etc/config/app.ini", "key=value\n", "key", "value"},
+		{"/etc/config/app.properties", "key=value\n", "key", "value"},
+	}
+
+	for _, c := range cases {
+		config, err := handlerFor(c.name)(c.name, []byte(c.content))
+		if err != nil {
+			t.Fatalf("handlerFor(%q) handler returned error: %s", c.name, err)
+		}
+		if got := config[c.key]; got != c.want {
+			t.Errorf("handlerFor(%q) handler[%q] = %v, want %v", c.name, c.key, got, c.want)
+		}
+	}
+}
+
+func TestSniffFileHandler(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		key     string
+		want    interface{}
+	}{
+		{"app.conf", `{"key":"value"}`, "key", "value"},
+		{"app.conf", "key: value\n", "key", "value"},
+		{"app.conf", "key=value\n", "key", "value"},
+	}
+
+	for _, c := range cases {
+		config, err := sniffFileHandler(c.name, []byte(c.content))
+		if err != nil {
+			t.Fatalf("sniffFileHandler(%q) returned error: %s", c.content, err)
+		}
+		if got := config[c.key]; got != c.want {
+			t.Errorf("sniffFileHandler(%q)[%q] = %v, want %v", c.content, c.key, got, c.want)
+		}
+	}
+}
+
+func TestFlattenConfig(t *testing.T) {
+	in := map[string]interface{}{
+		"top": "value",
+		"nested": map[string]interface{}{
+			"child": "value2",
+			"deeper": map[interface{}]interface{}{
+				"grandchild": "value3",
+			},
+		},
+	}
+
+	out := make(map[string]interface{})
+	flattenConfig("", in, out)
+
+	want := map[string]interface{}{
+		"top":                      "value",
+		"nested.child":             "value2",
+		"nested.deeper.grandchild": "value3",
+	}
+
+	if !reflect.DeepEqual(out, want) {
+		t.Errorf("flattenConfig() = %#v, want %#v", out, want)
+	}
+}
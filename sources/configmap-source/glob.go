@@ -0,0 +1,236 @@
+/*
+ * Copyright 2019 Huawei Technologies Co., Ltd
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package configmapource
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/go-chassis/go-archaius/sources/utils"
+	"github.com/go-mesh/openlogging"
+)
+
+//AddFilesOption customizes a pattern registered through AddFiles
+type AddFilesOption func(*globReg)
+
+//WithExclude skips any path matched by pattern that also matches one of the
+//given regexps, mirroring the exclude_regexps pattern used by file
+//acquisition tools to keep noise out of a drop-in directory
+func WithExclude(patterns ...string) AddFilesOption {
+	return func(g *globReg) {
+		for _, p := range patterns {
+			re, err := regexp.Compile(p)
+			if err != nil {
+				openlogging.GetLogger().Errorf("invalid exclude pattern [%s]: %s", p, err)
+				continue
+			}
+			g.exclude = append(g.exclude, re)
+		}
+	}
+}
+
+//globReg is a pattern registered through AddFiles, remembered so that files
+//created later under root can be auto-registered with the same priority
+//and handler instead of falling back to path-prefix inference
+type globReg struct {
+	root     string
+	pattern  string
+	priority uint32
+	handler  utils.FileHandler
+	exclude  []*regexp.Regexp
+}
+
+func (g *globReg) matches(path string) bool {
+	ok, err := matchGlob(g.pattern, path)
+	if err != nil || !ok {
+		return false
+	}
+
+	for _, re := range g.exclude {
+		if re.MatchString(path) {
+			return false
+		}
+	}
+
+	return true
+}
+
+//AddFiles registers every file under the nearest non-glob ancestor directory
+//of pattern that matches it, e.g. "/etc/config/*.yaml" or
+//"/etc/config/**/*.properties". Files created later under that directory
+//which match the pattern are auto-registered with priority and handle on
+//fsnotify.Create, making the source a proper drop-in-directory config loader
+func (cmSource *configMapSource) AddFiles(pattern string, priority uint32, handle utils.FileHandler, opts ...AddFilesOption) error {
+	abs, err := filepath.Abs(pattern)
+	if err != nil {
+		return err
+	}
+
+	reg := &globReg{root: globRoot(abs), pattern: abs, priority: priority, handler: handle}
+	for _, opt := range opts {
+		opt(reg)
+	}
+
+	matched := make([]string, 0)
+	err = filepath.Walk(reg.root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if reg.matches(p) {
+			matched = append(matched, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to match pattern [%s]: %s", pattern, err)
+	}
+
+	cmSource.addGlob(reg)
+
+	if cmSource.watchPool != nil {
+		cmSource.watchPool.watchGlobRoot(reg)
+	}
+
+	for _, p := range matched {
+		if err := cmSource.AddFile(p, priority, handle); err != nil {
+			openlogging.GetLogger().Errorf("failed to register [%s] matched by pattern [%s]: %s", p, pattern, err)
+		}
+	}
+
+	return nil
+}
+
+//globRootFor returns the registration whose root is the nearest ancestor of,
+//or equal to, path, so a directory discovered later by the watcher can be
+//attributed back to the pattern that should watch it
+func (cmSource *configMapSource) globRootFor(path string) (*globReg, bool) {
+	var best *globReg
+	bestLen := -1
+
+	for _, g := range cmSource.globsSnapshot() {
+		if g.root != path && !strings.HasPrefix(path, g.root+string(filepath.Separator)) {
+			continue
+		}
+		if len(g.root) > bestLen {
+			bestLen, best = len(g.root), g
+		}
+	}
+
+	return best, best != nil
+}
+
+//addGlobDirectory watches dir and recurses into it, registering any file
+//already inside that matches the owning pattern. Called whenever the watcher
+//reports a new directory created under a glob root, and recursively for each
+//subdirectory found inside it, so that "**" patterns stay recursive as the
+//tree changes after startup
+func (cmSource *configMapSource) addGlobDirectory(dir string) {
+	reg, ok := cmSource.globRootFor(dir)
+	if !ok {
+		return
+	}
+
+	if cmSource.watchPool != nil {
+		cmSource.watchPool.AddWatchFile(dir)
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		openlogging.GetLogger().Errorf("failed to list new glob directory [%s]: %s", dir, err)
+		return
+	}
+
+	for _, entry := range entries {
+		p := filepath.Join(dir, entry.Name())
+		if entry.IsDir() {
+			cmSource.addGlobDirectory(p)
+			continue
+		}
+		if reg.matches(p) {
+			if err := cmSource.AddFile(p, reg.priority, reg.handler); err != nil {
+				openlogging.GetLogger().Errorf("failed to register [%s] matched by pattern [%s]: %s", p, reg.pattern, err)
+			}
+		}
+	}
+}
+
+//globRoot returns the longest ancestor directory of pattern that contains
+//no glob metacharacters, so it can be walked and watched directly. A pattern
+//with no metacharacters at all is returned whole
+func globRoot(pattern string) string {
+	parts := strings.Split(filepath.ToSlash(pattern), "/")
+	root := make([]string, 0, len(parts))
+	for _, p := range parts {
+		if strings.ContainsAny(p, "*?[") {
+			break
+		}
+		root = append(root, p)
+	}
+
+	if len(root) == 0 {
+		return string(filepath.Separator)
+	}
+
+	return filepath.FromSlash(strings.Join(root, "/"))
+}
+
+//matchGlob reports whether path matches pattern, where each "/"-separated
+//component is matched with filepath.Match except for a literal "**"
+//component, which matches any number of intermediate directories
+func matchGlob(pattern, path string) (bool, error) {
+	return matchGlobParts(
+		strings.Split(filepath.ToSlash(pattern), "/"),
+		strings.Split(filepath.ToSlash(path), "/"),
+	)
+}
+
+func matchGlobParts(pattern, path []string) (bool, error) {
+	if len(pattern) == 0 {
+		return len(path) == 0, nil
+	}
+
+	if pattern[0] == "**" {
+		if len(pattern) == 1 {
+			return true, nil
+		}
+		for i := 0; i <= len(path); i++ {
+			ok, err := matchGlobParts(pattern[1:], path[i:])
+			if err != nil || ok {
+				return ok, err
+			}
+		}
+		return false, nil
+	}
+
+	if len(path) == 0 {
+		return false, nil
+	}
+
+	ok, err := filepath.Match(pattern[0], path[0])
+	if err != nil || !ok {
+		return false, err
+	}
+
+	return matchGlobParts(pattern[1:], path[1:])
+}
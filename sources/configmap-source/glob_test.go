@@ -0,0 +1,66 @@
+/*
+ * Copyright 2019 Huawei Technologies Co., Ltd
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package configmapource
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestMatchGlob(t *testing.T) {
+	cases := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"/etc/config/*.yaml", "/etc/config/app.yaml", true},
+		{"/etc/config/*.yaml", "/etc/config/app.json", false},
+		{"/etc/config/*.yaml", "/etc/config/nested/app.yaml", false},
+		{"/etc/config/**/*.properties", "/etc/config/app.properties", true},
+		{"/etc/config/**/*.properties", "/etc/config/a/b/c/app.properties", true},
+		{"/etc/config/**/*.properties", "/etc/config/a/b/c/app.yaml", false},
+		{"/etc/config/**", "/etc/config/a/b/c", true},
+		{"/etc/config/**", "/etc/config", true},
+	}
+
+	for _, c := range cases {
+		got, err := matchGlob(c.pattern, c.path)
+		if err != nil {
+			t.Fatalf("matchGlob(%q, %q) returned error: %s", c.pattern, c.path, err)
+		}
+		if got != c.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", c.pattern, c.path, got, c.want)
+		}
+	}
+}
+
+func TestGlobRoot(t *testing.T) {
+	cases := []struct {
+		pattern string
+		want    string
+	}{
+		{"/etc/config/*.yaml", "/etc/config"},
+		{"/etc/config/**/*.properties", "/etc/config"},
+		{"/etc/config/sub/app.yaml", filepath.FromSlash("/etc/config/sub/app.yaml")},
+	}
+
+	for _, c := range cases {
+		if got := globRoot(c.pattern); got != c.want {
+			t.Errorf("globRoot(%q) = %q, want %q", c.pattern, got, c.want)
+		}
+	}
+}
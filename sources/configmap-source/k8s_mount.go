@@ -0,0 +1,175 @@
+/*
+ * Copyright 2019 Huawei Technologies Co., Ltd
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package configmapource
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-chassis/go-archaius/sources/utils"
+	"github.com/go-mesh/openlogging"
+)
+
+//k8sDataSymlink is the indirection Kubernetes swaps atomically on every
+//ConfigMap/Secret projected volume update: each key under the mount is a
+//symlink to "..data/<key>", and "..data" itself is a symlink to a
+//timestamped directory holding the current revision of every key
+const k8sDataSymlink = "..data"
+
+//configMapMount tracks the logical keys registered under a directory so
+//that an atomic "..data" swap can be followed by a reload of every key
+type configMapMount struct {
+	dir  string
+	keys []mountKey
+}
+
+type mountKey struct {
+	path     string
+	priority uint32
+	handler  utils.FileHandler
+}
+
+//isK8sDataSymlinkEvent reports whether a watcher event names the "..data"
+//entry of a Kubernetes projected volume mount
+func isK8sDataSymlinkEvent(name string) bool {
+	return filepath.Base(name) == k8sDataSymlink
+}
+
+//symlinkedMountDir reports the directory containing path when path is
+//itself a symlink (as every key in a Kubernetes projected volume mount is).
+//A single os.Lstat is enough here: every key symlink points at "..data/<key>"
+//directly, so its immediate parent is already the stable mount directory to
+//watch for the next "..data" swap. Fully resolving the chain with
+//filepath.EvalSymlinks would instead land on the current timestamped
+//revision directory, which Kubernetes replaces on every update, so it is
+//used elsewhere (reloadConfigMapMount) only to check that a key still
+//resolves, never to pick a directory to watch
+func symlinkedMountDir(path string) (string, bool) {
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		return "", false
+	}
+
+	return filepath.Dir(path), true
+}
+
+//AddConfigMapMount registers every key under a Kubernetes ConfigMap/Secret
+//projected volume mount (mountDir) and arranges for the atomic "..data"
+//symlink swap Kubernetes performs on update to reload every key, since that
+//swap never fires a Write event on the leaf files themselves
+func (cmSource *configMapSource) AddConfigMapMount(mountDir string, handler utils.FileHandler) error {
+	abs, err := filepath.Abs(mountDir)
+	if err != nil {
+		return err
+	}
+
+	entries, err := ioutil.ReadDir(abs)
+	if err != nil {
+		return fmt.Errorf("failed to list configmap mount [%s]: %s", abs, err)
+	}
+
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			// skips "..data" and the hidden timestamped revision directories
+			continue
+		}
+
+		keyPath := filepath.Join(abs, entry.Name())
+		if err := cmSource.AddFile(keyPath, DefaultConfigMapPriority, handler); err != nil {
+			return fmt.Errorf("failed to register configmap key [%s]: %s", keyPath, err)
+		}
+	}
+
+	return nil
+}
+
+//registerMountKey remembers that keyPath is a logical key living under dir,
+//so a later "..data" swap on dir can re-run handleFile for it
+func (cmSource *configMapSource) registerMountKey(dir, keyPath string, priority uint32, handler utils.FileHandler) {
+	cmSource.Lock()
+	defer cmSource.Unlock()
+
+	if cmSource.mounts == nil {
+		cmSource.mounts = make(map[string]*configMapMount)
+	}
+
+	mount, ok := cmSource.mounts[dir]
+	if !ok {
+		mount = &configMapMount{dir: dir}
+		cmSource.mounts[dir] = mount
+	}
+
+	for _, k := range mount.keys {
+		if k.path == keyPath {
+			return
+		}
+	}
+
+	mount.keys = append(mount.keys, mountKey{path: keyPath, priority: priority, handler: handler})
+}
+
+//mountDirs returns every directory registered through registerMountKey, so
+//startWatchPool can add a watch for mounts that were registered with AddFile
+//before DynamicConfigHandler created the watch pool
+func (cmSource *configMapSource) mountDirs() []string {
+	cmSource.Lock()
+	defer cmSource.Unlock()
+
+	dirs := make([]string, 0, len(cmSource.mounts))
+	for dir := range cmSource.mounts {
+		dirs = append(dirs, dir)
+	}
+	return dirs
+}
+
+//reloadConfigMapMount re-resolves the symlink chain of every key registered
+//under dir and re-reads each one, following the atomic "..data" swap
+//Kubernetes performs on ConfigMap/Secret updates
+func (cmSource *configMapSource) reloadConfigMapMount(dir string) {
+	cmSource.Lock()
+	mount, ok := cmSource.mounts[dir]
+	var keys []mountKey
+	if ok {
+		keys = append(keys, mount.keys...)
+	}
+	cmSource.Unlock()
+	if !ok {
+		return
+	}
+
+	for _, k := range keys {
+		if _, err := filepath.EvalSymlinks(k.path); err != nil {
+			openlogging.GetLogger().Warnf("[%s] no longer resolves through the configmap mount: %s", k.path, err)
+			continue
+		}
+
+		fs, err := os.Open(k.path)
+		if err != nil {
+			openlogging.GetLogger().Errorf("failed to open [%s] after configmap reload: %s", k.path, err)
+			continue
+		}
+
+		err = cmSource.handleFile(fs, k.priority, k.handler)
+		fs.Close()
+		if err != nil {
+			openlogging.GetLogger().Errorf("failed to handle [%s] after configmap reload: %s", k.path, err)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+/*
+ * Copyright 2019 Huawei Technologies Co., Ltd
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package configmapource
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-mesh/openlogging"
+)
+
+//defaultPollInterval is used when WithPollingWatcher is given a non-positive interval
+const defaultPollInterval = 5 * time.Second
+
+//pollWatcher detects file changes by periodically walking the registered
+//paths and comparing mtimes, for filesystems where inotify is unreliable
+//(NFS, overlayfs, Kubernetes projected volumes on some kernels)
+type pollWatcher struct {
+	interval time.Duration
+	events   chan Event
+	errors   chan error
+	done     chan struct{}
+
+	mu    sync.Mutex
+	paths map[string]bool
+	cache map[string]time.Time
+}
+
+//newPollWatcher creates a Watcher that polls instead of relying on inotify
+func newPollWatcher(interval time.Duration) Watcher {
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+
+	pw := &pollWatcher{
+		interval: interval,
+		events:   make(chan Event),
+		errors:   make(chan error),
+		done:     make(chan struct{}),
+		paths:    make(map[string]bool),
+		cache:    make(map[string]time.Time),
+	}
+	go pw.run()
+	return pw
+}
+
+func (pw *pollWatcher) Add(path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	pw.mu.Lock()
+	pw.paths[abs] = true
+	pw.mu.Unlock()
+
+	//seed the cache so the first poll tick does not report the newly added
+	//path as a change
+	pw.scanOnce(true)
+	return nil
+}
+
+func (pw *pollWatcher) Remove(path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	pw.mu.Lock()
+	delete(pw.paths, abs)
+	pw.mu.Unlock()
+	return nil
+}
+
+func (pw *pollWatcher) Events() <-chan Event {
+	return pw.events
+}
+
+func (pw *pollWatcher) Errors() <-chan error {
+	return pw.errors
+}
+
+func (pw *pollWatcher) Close() error {
+	close(pw.done)
+	return nil
+}
+
+func (pw *pollWatcher) run() {
+	ticker := time.NewTicker(pw.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			pw.scanOnce(false)
+		case <-pw.done:
+			return
+		}
+	}
+}
+
+//emit sends e on pw.events, giving up if pw.done closes first so a consumer
+//that has stopped draining Events() (or a Close racing this scan) can never
+//block run()'s goroutine forever
+func (pw *pollWatcher) emit(e Event) {
+	select {
+	case pw.events <- e:
+	case <-pw.done:
+	}
+}
+
+//scanOnce walks every registered path once, coalescing the files found
+//under a registered directory into a single pass, and emits one Event per
+//changed file. When seed is true, the cache is primed but no events fire,
+//so Add does not generate a spurious change for a freshly watched path
+func (pw *pollWatcher) scanOnce(seed bool) {
+	pw.mu.Lock()
+	paths := make([]string, 0, len(pw.paths))
+	for p := range pw.paths {
+		paths = append(paths, p)
+	}
+	pw.mu.Unlock()
+
+	seen := make(map[string]bool)
+	for _, root := range paths {
+		err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			if info.IsDir() {
+				return nil
+			}
+
+			seen[p] = true
+			pw.mu.Lock()
+			last, known := pw.cache[p]
+			pw.cache[p] = info.ModTime()
+			pw.mu.Unlock()
+
+			if seed {
+				return nil
+			}
+
+			if !known {
+				pw.emit(Event{Name: p, Op: Create})
+			} else if !last.Equal(info.ModTime()) {
+				pw.emit(Event{Name: p, Op: Write})
+			}
+			return nil
+		})
+		if err != nil {
+			openlogging.GetLogger().Errorf("poll watcher failed to walk [%s]: %s", root, err)
+			continue
+		}
+	}
+
+	if seed {
+		return
+	}
+
+	pw.mu.Lock()
+	removed := make([]string, 0)
+	for p := range pw.cache {
+		if !seen[p] {
+			delete(pw.cache, p)
+			removed = append(removed, p)
+		}
+	}
+	pw.mu.Unlock()
+
+	for _, p := range removed {
+		pw.emit(Event{Name: p, Op: Remove})
+	}
+}
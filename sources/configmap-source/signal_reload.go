@@ -0,0 +1,139 @@
+/*
+ * Copyright 2019 Huawei Technologies Co., Ltd
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package configmapource
+
+import (
+	"os"
+	"os/signal"
+	"path/filepath"
+
+	"github.com/go-mesh/openlogging"
+)
+
+//EnableSignalReload makes the source perform a full re-read of every
+//registered file whenever the process receives sig, emitting
+//core.Update/core.Create/core.Delete through the same compareUpdate
+//pipeline fsnotify-triggered reloads use. Off by default; valuable when
+//configs live on a filesystem that does not propagate inotify (bind mounts
+//across containers, sshfs, some CI sandboxes) or when an operator wants to
+//force a reload after editing multiple files atomically
+func (cmSource *configMapSource) EnableSignalReload(sig os.Signal) error {
+	cmSource.signalLock.Lock()
+	defer cmSource.signalLock.Unlock()
+
+	if cmSource.signalChan != nil {
+		signal.Stop(cmSource.signalChan)
+		close(cmSource.signalChan)
+	}
+
+	ch := make(chan os.Signal, 1)
+	cmSource.signalChan = ch
+	signal.Notify(ch, sig)
+
+	go cmSource.watchSignal(ch)
+	return nil
+}
+
+func (cmSource *configMapSource) watchSignal(ch chan os.Signal) {
+	for range ch {
+		openlogging.GetLogger().Info("signal reload: re-reading every registered file")
+		cmSource.reloadAll()
+	}
+}
+
+//reloadAll re-walks every registered root and AddFiles pattern to pick up
+//files created since the last reload, then re-reads every file currently
+//registered with AddFile/AddFiles, reusing handleFile so the resulting
+//events go through the usual compareUpdate/callback pipeline. A file that
+//has disappeared has its keys explicitly deleted instead of merely being
+//skipped, so SIGHUP keeps its "emitting core.Update/core.Create/core.Delete"
+//promise even on a filesystem that cannot propagate inotify
+func (cmSource *configMapSource) reloadAll() {
+	cmSource.rediscoverRegisteredPaths()
+
+	for _, filePath := range cmSource.filePaths() {
+		path, err := cmSource.getFilePath(filePath)
+		if err != nil {
+			openlogging.GetLogger().Warnf("signal reload: [%s] no longer exists, deleting its configuration: %s", filePath, err)
+			cmSource.forgetFile(filePath)
+			continue
+		}
+
+		fs, err := os.Open(path)
+		if err != nil {
+			openlogging.GetLogger().Errorf("signal reload: failed to open [%s]: %s", path, err)
+			continue
+		}
+
+		meta, _ := cmSource.fileMetaFor(filePath)
+		err = cmSource.handleFile(fs, meta.priority, meta.handler)
+		fs.Close()
+		if err != nil {
+			openlogging.GetLogger().Errorf("signal reload: failed to handle [%s]: %s", path, err)
+		}
+	}
+}
+
+//rediscoverRegisteredPaths re-walks every directory registered through
+//AddFile and every pattern registered through AddFiles, registering any file
+//found that is not already known. This is what lets SIGHUP pick up a file
+//dropped into a watched directory on a filesystem that never fires the
+//fsnotify Create event AddFile/AddFiles otherwise rely on
+func (cmSource *configMapSource) rediscoverRegisteredPaths() {
+	for _, root := range cmSource.registeredRoots() {
+		meta, ok := cmSource.registrationFor(root)
+		if !ok {
+			continue
+		}
+		if err := cmSource.AddFile(root, meta.priority, meta.handler); err != nil {
+			openlogging.GetLogger().Warnf("signal reload: failed to rescan [%s]: %s", root, err)
+		}
+	}
+
+	for _, g := range cmSource.globsSnapshot() {
+		err := filepath.Walk(g.root, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() && g.matches(p) {
+				if err := cmSource.AddFile(p, g.priority, g.handler); err != nil {
+					openlogging.GetLogger().Warnf("signal reload: failed to register [%s]: %s", p, err)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			openlogging.GetLogger().Warnf("signal reload: failed to rescan pattern [%s]: %s", g.pattern, err)
+		}
+	}
+}
+
+//forgetFile publishes a core.Delete for every key filePath contributed, then
+//removes filePath from the registered-file state, so a later re-creation of
+//the same path is treated as new again instead of a no-op
+func (cmSource *configMapSource) forgetFile(filePath string) {
+	events := cmSource.compareUpdate(map[string]interface{}{}, filePath)
+	if cmSource.watchPool != nil && cmSource.watchPool.callback != nil {
+		for _, e := range events {
+			cmSource.watchPool.callback.OnEvent(e)
+		}
+	}
+
+	cmSource.filesMu.Lock()
+	delete(cmSource.files, filePath)
+	cmSource.filesMu.Unlock()
+}
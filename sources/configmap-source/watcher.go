@@ -0,0 +1,140 @@
+/*
+ * Copyright 2019 Huawei Technologies Co., Ltd
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package configmapource
+
+import (
+	"github.com/fsnotify/fsnotify"
+)
+
+//Op describes the kind of file change a Watcher observed
+type Op uint32
+
+const (
+	//Create is fired when a new file appears under a watched path
+	Create Op = 1 << iota
+	//Write is fired when a watched file's content changed; the fsnotify
+	//backend also reports any other change (e.g. chmod) as Write
+	Write
+	//Remove is fired when a watched file or its parent disappeared
+	Remove
+	//Rename is fired when a watched file got renamed away
+	Rename
+)
+
+//Event is a backend-agnostic file change notification, decoupled from fsnotify
+//so that Watcher implementations other than fsnotify can feed the same pipeline
+type Event struct {
+	Name string
+	Op   Op
+}
+
+//Watcher is the backend used by the watch pool to learn about file changes.
+//fsnotify is the default backend, a polling backend is provided for
+//filesystems where inotify is unreliable (NFS, overlayfs, some Kubernetes
+//projected volumes) or when the fsnotify watch limit has been exhausted
+type Watcher interface {
+	//Add registers a path (file or directory) for change notifications
+	Add(path string) error
+	//Remove unregisters a previously added path
+	Remove(path string) error
+	//Events streams change notifications until Close is called
+	Events() <-chan Event
+	//Errors streams backend errors until Close is called
+	Errors() <-chan error
+	//Close releases resources held by the watcher
+	Close() error
+}
+
+//fsNotifyWatcher adapts an *fsnotify.Watcher to the Watcher interface
+type fsNotifyWatcher struct {
+	watcher *fsnotify.Watcher
+	events  chan Event
+	errors  chan error
+	done    chan struct{}
+}
+
+//newFsNotifyWatcher creates the default, inotify-backed Watcher
+func newFsNotifyWatcher() (Watcher, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	fw := &fsNotifyWatcher{
+		watcher: w,
+		events:  make(chan Event),
+		errors:  make(chan error),
+		done:    make(chan struct{}),
+	}
+	go fw.translate()
+	return fw, nil
+}
+
+func (fw *fsNotifyWatcher) translate() {
+	for {
+		select {
+		case event, ok := <-fw.watcher.Events:
+			if !ok {
+				close(fw.events)
+				return
+			}
+			fw.events <- Event{Name: event.Name, Op: fromFsnotifyOp(event.Op)}
+		case err, ok := <-fw.watcher.Errors:
+			if !ok {
+				close(fw.errors)
+				return
+			}
+			fw.errors <- err
+		case <-fw.done:
+			return
+		}
+	}
+}
+
+func fromFsnotifyOp(op fsnotify.Op) Op {
+	switch {
+	case op&fsnotify.Create != 0:
+		return Create
+	case op&fsnotify.Remove != 0:
+		return Remove
+	case op&fsnotify.Rename != 0:
+		return Rename
+	default:
+		return Write
+	}
+}
+
+func (fw *fsNotifyWatcher) Add(path string) error {
+	return fw.watcher.Add(path)
+}
+
+func (fw *fsNotifyWatcher) Remove(path string) error {
+	return fw.watcher.Remove(path)
+}
+
+func (fw *fsNotifyWatcher) Events() <-chan Event {
+	return fw.events
+}
+
+func (fw *fsNotifyWatcher) Errors() <-chan error {
+	return fw.errors
+}
+
+func (fw *fsNotifyWatcher) Close() error {
+	close(fw.done)
+	return fw.watcher.Close()
+}